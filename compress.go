@@ -0,0 +1,178 @@
+// Copyright 2015 Robin Hahling. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package targo
+
+import (
+	"bufio"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// Compression identifies the compression codec wrapping a tar stream.
+type Compression int
+
+// Supported compression codecs.
+const (
+	CompressionNone Compression = iota
+	CompressionGzip
+	CompressionBzip2
+	CompressionXz
+	CompressionZstd
+)
+
+// magic bytes used by detectCompression to sniff a stream's codec.
+var magicBytes = []struct {
+	compression Compression
+	magic       []byte
+}{
+	{CompressionGzip, []byte{0x1f, 0x8b}},
+	{CompressionBzip2, []byte{0x42, 0x5a, 0x68}},
+	{CompressionXz, []byte{0xfd, 0x37, 0x7a, 0x58, 0x5a, 0x00}},
+	{CompressionZstd, []byte{0x28, 0xb5, 0x2f, 0xfd}},
+}
+
+// DetectCompression sniffs the first few bytes of r to determine which
+// compression codec, if any, it is encoded with. It returns a reader that
+// replays those bytes, so the original reader must not be used afterwards.
+func DetectCompression(r io.Reader) (Compression, io.Reader, error) {
+	br := bufio.NewReader(r)
+
+	for _, m := range magicBytes {
+		peek, err := br.Peek(len(m.magic))
+		if err != nil && err != io.EOF {
+			return CompressionNone, nil, err
+		}
+		if bytesHasPrefix(peek, m.magic) {
+			return m.compression, br, nil
+		}
+	}
+
+	return CompressionNone, br, nil
+}
+
+func bytesHasPrefix(b, prefix []byte) bool {
+	if len(b) < len(prefix) {
+		return false
+	}
+	for i, c := range prefix {
+		if b[i] != c {
+			return false
+		}
+	}
+	return true
+}
+
+// NewCompressor returns a WriteCloser that compresses data written to it
+// with c before forwarding it to w. Closing it flushes any buffered data;
+// it does not close w.
+func NewCompressor(w io.Writer, c Compression) (io.WriteCloser, error) {
+	switch c {
+	case CompressionNone:
+		return nopWriteCloser{w}, nil
+	case CompressionGzip:
+		return gzip.NewWriter(w), nil
+	case CompressionXz:
+		return xz.NewWriter(w)
+	case CompressionZstd:
+		return zstd.NewWriter(w)
+	case CompressionBzip2:
+		return nil, fmt.Errorf("targo: writing bzip2 is not supported, only decompression is")
+	default:
+		return nil, fmt.Errorf("targo: unknown compression: %v", c)
+	}
+}
+
+// NewDecompressor returns a reader that decompresses data read from r
+// according to c.
+func NewDecompressor(r io.Reader, c Compression) (io.Reader, error) {
+	switch c {
+	case CompressionNone:
+		return r, nil
+	case CompressionGzip:
+		return gzip.NewReader(r)
+	case CompressionBzip2:
+		return bzip2.NewReader(r), nil
+	case CompressionXz:
+		return xz.NewReader(r)
+	case CompressionZstd:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("targo: unknown compression: %v", c)
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// compressWriter wraps w with the compressor selected by opts.Compression,
+// if any. The returned close func flushes and closes the compressor (but
+// never w itself) and must always be called, even on a nil opts.
+func compressWriter(w io.Writer, opts *CreateOptions) (io.Writer, func() error, error) {
+	var c Compression
+	if opts != nil {
+		c = opts.Compression
+	}
+	if c == CompressionNone {
+		return w, func() error { return nil }, nil
+	}
+
+	cw, err := NewCompressor(w, c)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return cw, cw.Close, nil
+}
+
+// archiveExts maps the file extensions Create/Extract recognize to their
+// compression codec, ordered longest-suffix-first so ".tar.gz" is matched
+// before the bare ".gz" would be.
+var archiveExts = []struct {
+	ext         string
+	compression Compression
+}{
+	{".tar.gz", CompressionGzip},
+	{".tgz", CompressionGzip},
+	{".tar.bz2", CompressionBzip2},
+	{".tar.xz", CompressionXz},
+	{".tar.zst", CompressionZstd},
+	{".tar", CompressionNone},
+}
+
+// compressionExt returns the file extension CreateInPlace should use for an
+// archive compressed with c.
+func compressionExt(c Compression) string {
+	for _, e := range archiveExts {
+		if e.compression == c {
+			return e.ext
+		}
+	}
+	return ".tar"
+}
+
+// splitArchiveExt returns path with its archive extension (".tar",
+// ".tar.gz", ".tgz", ".tar.bz2", ".tar.xz" or ".tar.zst") removed, and
+// whether path had one of those extensions.
+func splitArchiveExt(path string) (string, bool) {
+	for _, e := range archiveExts {
+		if strings.HasSuffix(path, e.ext) {
+			return path[:len(path)-len(e.ext)], true
+		}
+	}
+	return path, false
+}
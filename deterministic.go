@@ -0,0 +1,87 @@
+// Copyright 2015 Robin Hahling. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package targo
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// deterministicPAXRecords are written to every header of a Deterministic
+// archive, on top of whatever tar.FileInfoHeader itself produces, so two
+// archives of the same tree are byte-for-byte identical regardless of when
+// or by whom they were built.
+var deterministicPAXRecords = map[string]string{
+	"comment": "targo: deterministic archive",
+}
+
+// normalizeHeader rewrites hdr in place for reproducibility when
+// opts.Deterministic is set: timestamps are zeroed (or pinned to
+// opts.SourceDateEpoch), ownership is stripped, and the header is forced
+// into PAX format with a fixed set of records. It is a no-op otherwise.
+//
+// Entries are already written in the lexical order filepath.Walk guarantees
+// (it sorts each directory's children by name before recursing), so that
+// part of reproducibility needs no extra work here.
+func normalizeHeader(hdr *tar.Header, opts *CreateOptions) {
+	if opts == nil || !opts.Deterministic {
+		return
+	}
+
+	epoch := opts.SourceDateEpoch
+	hdr.ModTime = epoch
+	hdr.AccessTime = epoch
+	hdr.ChangeTime = epoch
+	hdr.Uid = 0
+	hdr.Gid = 0
+	hdr.Uname = ""
+	hdr.Gname = ""
+	hdr.Format = tar.FormatPAX
+	hdr.PAXRecords = deterministicPAXRecords
+}
+
+// relativeSymlinkTarget rewrites an absolute symlink target found at path
+// to be relative to path's containing directory, so the archive does not
+// bake in the host's absolute filesystem layout. Targets that are already
+// relative are returned unchanged.
+func relativeSymlinkTarget(path, target string) string {
+	if !filepath.IsAbs(target) {
+		return target
+	}
+
+	dirAbs, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return target
+	}
+
+	rel, err := filepath.Rel(dirAbs, target)
+	if err != nil {
+		return target
+	}
+
+	return filepath.ToSlash(rel)
+}
+
+// Checksum returns the hex-encoded SHA-256 digest of the archive at
+// archivePath. It is primarily useful to assert that CreateOptions.Deterministic
+// produces byte-for-byte identical output across runs.
+func Checksum(archivePath string) (string, error) {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,253 @@
+// Copyright 2015 Robin Hahling. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package targo
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ExtractOptions configures the behavior of ExtractWithOptions.
+type ExtractOptions struct {
+	// AllowUnsafePaths disables validation of entry names and symlink
+	// targets. When false (the default), any entry whose resolved path
+	// would escape the destination directory is rejected with a
+	// *PathTraversalError instead of being written.
+	AllowUnsafePaths bool
+
+	// Chroot extracts the archive inside a chroot sandbox rooted at the
+	// destination directory instead of relying on path validation alone.
+	// It is implemented by re-executing the current binary through a
+	// private multicall entrypoint and is only supported on Linux and the
+	// BSDs; see extractChroot.
+	Chroot bool
+
+	// AllowUnsafeLinks disables the check that a symlink's resolved
+	// target stays within the destination directory, without otherwise
+	// relaxing AllowUnsafePaths' validation of entry names.
+	AllowUnsafeLinks bool
+}
+
+// PathTraversalError reports an archive entry whose name or link target
+// would resolve outside of the extraction destination directory.
+type PathTraversalError struct {
+	Name string
+}
+
+func (e *PathTraversalError) Error() string {
+	return fmt.Sprintf("targo: entry escapes destination directory: %s", e.Name)
+}
+
+// Extract extracts the tar archive located at archivePath into destDir.
+//
+// Extract trusts the archive: entry names and symlink targets are used as
+// found, which is unsafe for archives of unknown origin. Use
+// ExtractWithOptions (or ExtractInPlace, which is safe by default) to
+// extract untrusted archives.
+func Extract(destDir, archivePath string) error {
+	return ExtractWithOptions(destDir, archivePath, &ExtractOptions{AllowUnsafePaths: true})
+}
+
+// ExtractWithOptions extracts the tar archive located at archivePath into
+// destDir according to opts. A nil opts is equivalent to a zero-value
+// ExtractOptions, meaning path-traversal validation is enabled and no
+// sandbox is used.
+func ExtractWithOptions(destDir, archivePath string, opts *ExtractOptions) error {
+	if opts == nil {
+		opts = &ExtractOptions{}
+	}
+
+	if opts.Chroot {
+		return extractChroot(destDir, archivePath, opts)
+	}
+
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return ExtractReader(f, destDir, opts)
+}
+
+// ExtractReader is like ExtractWithOptions but reads the archive from r
+// instead of a file, letting callers pipe archives over sockets or HTTP
+// without touching disk. The compression codec, if any, is detected by
+// sniffing r rather than being specified up front. opts.Chroot is not
+// supported here, since the sandbox needs a file path to re-exec with.
+func ExtractReader(r io.Reader, destDir string, opts *ExtractOptions) error {
+	if opts != nil && opts.Chroot {
+		return fmt.Errorf("targo: ExtractReader does not support the Chroot option")
+	}
+
+	compression, br, err := DetectCompression(r)
+	if err != nil {
+		return err
+	}
+
+	dr, err := NewDecompressor(br, compression)
+	if err != nil {
+		return err
+	}
+	if c, ok := dr.(io.Closer); ok {
+		// zstd.Decoder.IOReadCloser, in particular, runs background
+		// goroutines that are only released on Close.
+		defer c.Close()
+	}
+
+	return extractEntries(tar.NewReader(dr), destDir, opts)
+}
+
+// extractEntries reads every entry from tr and writes it under destDir.
+func extractEntries(tr *tar.Reader, destDir string, opts *ExtractOptions) error {
+	if opts == nil {
+		opts = &ExtractOptions{}
+	}
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		target, err := safePath(destDir, hdr.Name, opts)
+		if err != nil {
+			return err
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, os.FileMode(hdr.Mode)); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := extractSymlink(hdr, target, destDir, opts); err != nil {
+				return err
+			}
+		case tar.TypeLink:
+			if err := extractHardlink(hdr, target, destDir, opts); err != nil {
+				return err
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			if err := extractRegular(tr, target, hdr); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("targo: unsupported entry type %v for %s", hdr.Typeflag, hdr.Name)
+		}
+	}
+}
+
+// extractRegular writes the contents of tr to target as a regular file.
+func extractRegular(tr *tar.Reader, target string, hdr *tar.Header) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(hdr.Mode))
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, tr)
+	return err
+}
+
+// extractSymlink creates the symlink described by hdr at target, rejecting
+// link targets that resolve outside of destDir unless opts.AllowUnsafePaths
+// or opts.AllowUnsafeLinks is set.
+func extractSymlink(hdr *tar.Header, target, destDir string, opts *ExtractOptions) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	if opts == nil || !(opts.AllowUnsafePaths || opts.AllowUnsafeLinks) {
+		if _, err := safeLinkTarget(destDir, target, hdr.Linkname); err != nil {
+			return err
+		}
+	}
+
+	return os.Symlink(hdr.Linkname, target)
+}
+
+// extractHardlink recreates the hardlink described by hdr at target via
+// os.Link, rejecting a Linkname that resolves outside of destDir unless
+// opts.AllowUnsafePaths is set.
+func extractHardlink(hdr *tar.Header, target, destDir string, opts *ExtractOptions) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	oldpath := filepath.Join(destDir, filepath.Clean(hdr.Linkname))
+	if opts == nil || !opts.AllowUnsafePaths {
+		if err := checkWithinDir(destDir, oldpath, hdr.Linkname); err != nil {
+			return err
+		}
+	}
+
+	return os.Link(oldpath, target)
+}
+
+// safePath joins destDir and name, rejecting any result that would resolve
+// outside of destDir unless opts.AllowUnsafePaths is set.
+func safePath(destDir, name string, opts *ExtractOptions) (string, error) {
+	if opts != nil && opts.AllowUnsafePaths {
+		return filepath.Join(destDir, name), nil
+	}
+
+	target := filepath.Join(destDir, filepath.Clean(name))
+
+	if err := checkWithinDir(destDir, target, name); err != nil {
+		return "", err
+	}
+
+	return target, nil
+}
+
+// safeLinkTarget resolves a (possibly relative) symlink target against the
+// directory containing linkPath and verifies it stays within destDir.
+func safeLinkTarget(destDir, linkPath, linkname string) (string, error) {
+	resolved := linkname
+	if !filepath.IsAbs(resolved) {
+		resolved = filepath.Join(filepath.Dir(linkPath), resolved)
+	}
+
+	if err := checkWithinDir(destDir, resolved, linkname); err != nil {
+		return "", err
+	}
+
+	return resolved, nil
+}
+
+// checkWithinDir returns a *PathTraversalError (named after entryName) if
+// target does not resolve to destDir or one of its descendants.
+func checkWithinDir(destDir, target, entryName string) error {
+	destAbs, err := filepath.Abs(destDir)
+	if err != nil {
+		return err
+	}
+	targetAbs, err := filepath.Abs(target)
+	if err != nil {
+		return err
+	}
+
+	prefix := destAbs
+	if !strings.HasSuffix(prefix, string(filepath.Separator)) {
+		prefix += string(filepath.Separator)
+	}
+	if targetAbs != destAbs && !strings.HasPrefix(targetAbs, prefix) {
+		return &PathTraversalError{Name: entryName}
+	}
+
+	return nil
+}
@@ -0,0 +1,92 @@
+// Copyright 2015 Robin Hahling. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package targo
+
+import (
+	"os"
+	"time"
+)
+
+// CreateOptions configures the behavior of CreateWithOptions.
+type CreateOptions struct {
+	// PreserveHardlinks detects paths that share an inode with an
+	// earlier-visited path during the walk and archives the duplicate as a
+	// tar.TypeLink entry pointing at the first path's archive name, instead
+	// of writing its content a second time.
+	PreserveHardlinks bool
+
+	// Compression selects the codec the tar stream is wrapped with.
+	// CompressionNone (the default) writes a plain, uncompressed tar.
+	Compression Compression
+
+	// Includes, if non-empty, whitelists paths whose archive-relative name
+	// matches at least one of these doublestar (**-aware) glob patterns;
+	// everything else is skipped.
+	Includes []string
+
+	// Excludes skips any path whose archive-relative name matches one of
+	// these doublestar glob patterns. An excluded directory is skipped
+	// entirely, without descending into it. Excludes take precedence over
+	// Includes.
+	Excludes []string
+
+	// Matcher, if set, is consulted last: a path is only archived if it
+	// also passes Matcher.Match. Use this to plug in a custom predicate
+	// beyond Includes/Excludes and .targoignore.
+	Matcher Matcher
+
+	// Deterministic makes CreateWriter produce a byte-for-byte reproducible
+	// archive for a given source tree: per-entry timestamps are zeroed (or
+	// pinned to SourceDateEpoch), ownership is stripped, the header format
+	// is forced to tar.FormatPAX with a fixed set of records, and absolute
+	// symlink targets are rewritten relative to their containing directory.
+	Deterministic bool
+
+	// SourceDateEpoch is the timestamp written to every entry's ModTime,
+	// AccessTime and ChangeTime when Deterministic is set. The zero Time
+	// (the default) writes the Unix epoch.
+	SourceDateEpoch time.Time
+
+	// SymlinkPolicy controls how symlinks encountered while walking the
+	// source directory are archived. The zero value, SymlinkPreserve,
+	// matches targo's historical behavior of archiving the link verbatim.
+	SymlinkPolicy SymlinkPolicy
+}
+
+// hardlinks tracks the archive name each inode was first written under, so
+// that later paths sharing that inode can be archived as tar.TypeLink
+// entries instead of duplicating file content.
+type hardlinks map[interface{}]string
+
+// linkedName returns the archive name path's inode was already written
+// under, and whether that inode has been seen before. Non-regular files are
+// never tracked.
+func (h hardlinks) linkedName(path string, info os.FileInfo) (string, bool) {
+	if !info.Mode().IsRegular() {
+		return "", false
+	}
+
+	key, ok := inodeKey(path, info)
+	if !ok {
+		return "", false
+	}
+
+	name, seen := h[key]
+	return name, seen
+}
+
+// record remembers that path's inode was written under name.
+func (h hardlinks) record(path, name string, info os.FileInfo) {
+	if !info.Mode().IsRegular() {
+		return
+	}
+
+	key, ok := inodeKey(path, info)
+	if !ok {
+		return
+	}
+
+	h[key] = name
+}
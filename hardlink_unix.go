@@ -0,0 +1,31 @@
+// Copyright 2015 Robin Hahling. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly || solaris
+// +build linux darwin freebsd netbsd openbsd dragonfly solaris
+
+package targo
+
+import (
+	"os"
+	"syscall"
+)
+
+// devIno identifies a file by the (device, inode) pair reported by stat(2),
+// which is stable across hardlinks to the same file.
+type devIno struct {
+	dev uint64
+	ino uint64
+}
+
+// inodeKey returns the (device, inode) pair identifying info's underlying
+// file.
+func inodeKey(path string, info os.FileInfo) (interface{}, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil, false
+	}
+
+	return devIno{dev: uint64(st.Dev), ino: uint64(st.Ino)}, true
+}
@@ -0,0 +1,30 @@
+// Copyright 2015 Robin Hahling. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build windows
+// +build windows
+
+package targo
+
+import (
+	"os"
+	"syscall"
+)
+
+// inodeKey returns the nFileIndexHigh/nFileIndexLow pair Windows reports for
+// an open file handle, which is stable across hardlinks to the same file.
+func inodeKey(path string, info os.FileInfo) (interface{}, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, false
+	}
+	defer f.Close()
+
+	var fi syscall.ByHandleFileInformation
+	if err := syscall.GetFileInformationByHandle(syscall.Handle(f.Fd()), &fi); err != nil {
+		return nil, false
+	}
+
+	return uint64(fi.FileIndexHigh)<<32 | uint64(fi.FileIndexLow), true
+}
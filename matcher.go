@@ -0,0 +1,195 @@
+// Copyright 2015 Robin Hahling. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package targo
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+// Matcher decides whether an archive-relative path should be included when
+// walking a source directory for Create.
+type Matcher interface {
+	// Match reports whether rel, a slash-separated path relative to the
+	// archive root, should be included in the archive.
+	Match(rel string, isDir bool) bool
+}
+
+// MatcherFunc adapts a plain function to the Matcher interface.
+type MatcherFunc func(rel string, isDir bool) bool
+
+// Match calls f.
+func (f MatcherFunc) Match(rel string, isDir bool) bool { return f(rel, isDir) }
+
+// globMatcher implements CreateOptions.Includes/Excludes using doublestar
+// (**-aware) glob matching against the archive-relative path.
+type globMatcher struct {
+	includes []string
+	excludes []string
+}
+
+// Match reports whether rel passes the exclude list (if any) and the
+// include whitelist (if any).
+func (m *globMatcher) Match(rel string, isDir bool) bool {
+	if m.Excluded(rel) {
+		return false
+	}
+	if len(m.includes) > 0 && !matchAny(m.includes, rel) {
+		return false
+	}
+	return true
+}
+
+// Excluded reports whether rel matches one of m's exclude patterns. Unlike
+// Match, it does not consider the include whitelist: a directory that fails
+// to match Includes itself may still have descendants that do, so failing
+// the whitelist must not be treated as grounds to prune the whole subtree
+// the way a genuine Excludes match is.
+func (m *globMatcher) Excluded(rel string) bool {
+	return matchAny(m.excludes, rel)
+}
+
+func matchAny(patterns []string, rel string) bool {
+	for _, p := range patterns {
+		if ok, _ := doublestar.Match(p, rel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// targoignoreName is the file CreateWithOptions looks for in every
+// directory it walks, in the same spirit as .gitignore.
+const targoignoreName = ".targoignore"
+
+// ignoreRule is a single pattern line loaded from a .targoignore file.
+type ignoreRule struct {
+	pattern string
+	negate  bool
+}
+
+// ignoreScope is the set of rules loaded from the .targoignore found in a
+// single directory; it applies to that directory and its descendants until
+// a more deeply nested .targoignore overrides it.
+type ignoreScope struct {
+	dir   string
+	rules []ignoreRule
+}
+
+// match reports whether rel (relative to s.dir) is ignored by s, and
+// whether any of s's rules matched it at all. The last matching rule wins,
+// so a later "!pattern" re-includes a path an earlier rule excluded.
+func (s *ignoreScope) match(rel string) (ignored, matched bool) {
+	for _, r := range s.rules {
+		if ruleMatch(r.pattern, rel) {
+			matched = true
+			ignored = !r.negate
+		}
+	}
+	return ignored, matched
+}
+
+// ruleMatch matches pattern against rel either as an exact glob or, for
+// patterns with no leading "/" semantics of their own, at any depth.
+func ruleMatch(pattern, rel string) bool {
+	if ok, _ := doublestar.Match(pattern, rel); ok {
+		return true
+	}
+	ok, _ := doublestar.Match("**/"+pattern, rel)
+	return ok
+}
+
+// loadIgnoreFile parses the .gitignore-style file at path. A missing file
+// is not an error; it simply yields no rules.
+func loadIgnoreFile(path string) ([]ignoreRule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		negate := strings.HasPrefix(line, "!")
+		if negate {
+			line = line[1:]
+		}
+		rules = append(rules, ignoreRule{pattern: line, negate: negate})
+	}
+
+	return rules, scanner.Err()
+}
+
+// ignoreMatcher implements .targoignore lookup, tracking nested scopes as
+// the walk descends into subdirectories so a deeper file's rules take
+// precedence over an ancestor's.
+type ignoreMatcher struct {
+	scopes []*ignoreScope
+}
+
+func newIgnoreMatcher() *ignoreMatcher {
+	return &ignoreMatcher{}
+}
+
+// enter loads dir's own .targoignore, if any, and pushes it as the new
+// innermost scope, after dropping any scopes that no longer apply to dir.
+func (m *ignoreMatcher) enter(dir string) error {
+	m.trim(dir)
+
+	rules, err := loadIgnoreFile(filepath.Join(dir, targoignoreName))
+	if err != nil {
+		return err
+	}
+	if len(rules) > 0 {
+		m.scopes = append(m.scopes, &ignoreScope{dir: dir, rules: rules})
+	}
+
+	return nil
+}
+
+// trim drops scopes whose directory is not dir itself or an ancestor of it.
+func (m *ignoreMatcher) trim(dir string) {
+	n := 0
+	for _, s := range m.scopes {
+		if s.dir == dir || strings.HasPrefix(dir, s.dir+string(filepath.Separator)) {
+			m.scopes[n] = s
+			n++
+		}
+	}
+	m.scopes = m.scopes[:n]
+}
+
+// Match reports whether path should be included, consulting every
+// applicable scope from outermost to innermost so a nested .targoignore's
+// negation can re-include a path an ancestor excluded.
+func (m *ignoreMatcher) Match(path string) bool {
+	m.trim(filepath.Dir(path))
+
+	ignored := false
+	for _, s := range m.scopes {
+		rel, err := filepath.Rel(s.dir, path)
+		if err != nil {
+			continue
+		}
+		if i, matched := s.match(filepath.ToSlash(rel)); matched {
+			ignored = i
+		}
+	}
+
+	return !ignored
+}
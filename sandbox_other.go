@@ -0,0 +1,16 @@
+// Copyright 2015 Robin Hahling. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux && !freebsd && !netbsd && !openbsd && !dragonfly
+// +build !linux,!freebsd,!netbsd,!openbsd,!dragonfly
+
+package targo
+
+import "fmt"
+
+// extractChroot is unavailable on this platform: chroot(2) has no
+// equivalent here.
+func extractChroot(destDir, archivePath string, opts *ExtractOptions) error {
+	return fmt.Errorf("targo: chroot sandbox is not supported on this platform")
+}
@@ -0,0 +1,128 @@
+// Copyright 2015 Robin Hahling. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux || freebsd || netbsd || openbsd || dragonfly
+// +build linux freebsd netbsd openbsd dragonfly
+
+package targo
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+)
+
+// chrootHelperArg0 is the argv[0] extractChroot re-execs the binary under to
+// signal the multicall dispatch in init() below, in the style of
+// docker/pkg/reexec. Unlike an inherited environment variable, argv[0] is
+// set fresh by whoever spawns the process, so a consumer binary can't end up
+// here by accident; only extractChroot's own cmd.Args does this.
+const chrootHelperArg0 = "targo-chroot-helper"
+
+// Environment variables carrying the destination and archive paths to the
+// chroot-helper child once it has been dispatched via chrootHelperArg0.
+const (
+	chrootDestEnv    = "TARGO_CHROOT_DEST"
+	chrootArchiveEnv = "TARGO_CHROOT_ARCHIVE"
+)
+
+func init() {
+	if len(os.Args) > 0 && filepath.Base(os.Args[0]) == chrootHelperArg0 {
+		runChrootHelper()
+	}
+}
+
+// extractChroot extracts archivePath into destDir inside a chroot sandbox.
+// It re-executes the current binary with argv[0] set to chrootHelperArg0,
+// which causes runChrootHelper to take over in the child via the init()
+// above (a multicall entrypoint, in the style of busybox applets) before any
+// of the caller's own main runs. The child chroots into destDir so that any
+// path handling bug in extractEntries can, at worst, affect files already
+// inside the destination.
+func extractChroot(destDir, archivePath string, opts *ExtractOptions) error {
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	destAbs, err := filepath.Abs(destDir)
+	if err != nil {
+		return err
+	}
+	archiveAbs, err := filepath.Abs(archivePath)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(destAbs, 0755); err != nil {
+		return err
+	}
+
+	cmd := &exec.Cmd{
+		Path: self,
+		Args: []string{chrootHelperArg0},
+		Env: append(os.Environ(),
+			chrootDestEnv+"="+destAbs,
+			chrootArchiveEnv+"="+archiveAbs,
+		),
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+	}
+
+	return cmd.Run()
+}
+
+// runChrootHelper is the private subcommand re-exec'd by extractChroot. It
+// chroots into the destination directory and extracts the archive relative
+// to the new root, then terminates the process: it never returns control to
+// the caller's main.
+func runChrootHelper() {
+	dest := os.Getenv(chrootDestEnv)
+	archive := os.Getenv(chrootArchiveEnv)
+
+	// The archive must be opened before the chroot takes effect: once
+	// rooted at dest, archive's original absolute path is no longer
+	// reachable from the new filesystem view.
+	f, err := os.Open(archive)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "targo: open:", err)
+		os.Exit(1)
+	}
+	defer f.Close()
+
+	// Sniff and strip any compression codec the same way ExtractReader
+	// does, since the archive arrives here exactly as it was on disk.
+	compression, br, err := DetectCompression(f)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "targo: detect compression:", err)
+		os.Exit(1)
+	}
+	dr, err := NewDecompressor(br, compression)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "targo: decompress:", err)
+		os.Exit(1)
+	}
+	if c, ok := dr.(io.Closer); ok {
+		defer c.Close()
+	}
+
+	if err := syscall.Chroot(dest); err != nil {
+		fmt.Fprintln(os.Stderr, "targo: chroot:", err)
+		os.Exit(1)
+	}
+	if err := os.Chdir("/"); err != nil {
+		fmt.Fprintln(os.Stderr, "targo: chdir:", err)
+		os.Exit(1)
+	}
+
+	if err := extractEntries(tar.NewReader(dr), "/", &ExtractOptions{}); err != nil {
+		fmt.Fprintln(os.Stderr, "targo: extract:", err)
+		os.Exit(1)
+	}
+
+	os.Exit(0)
+}
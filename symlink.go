@@ -0,0 +1,141 @@
+// Copyright 2015 Robin Hahling. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package targo
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SymlinkPolicy controls how Create handles symlinks found while walking
+// the source directory.
+type SymlinkPolicy int
+
+const (
+	// SymlinkPreserve archives the symlink as-is, including absolute
+	// targets and targets that point outside of the source root. This is
+	// the default, and matches targo's historical behavior.
+	SymlinkPreserve SymlinkPolicy = iota
+
+	// SymlinkRewriteRelative rewrites an absolute symlink target to be
+	// relative to the directory containing the link, so the archive does
+	// not bake in the host's absolute filesystem layout.
+	SymlinkRewriteRelative
+
+	// SymlinkReject causes Create to fail with an *IllegalLinkError as
+	// soon as it finds a symlink whose target, once resolved against its
+	// containing directory, falls outside of the source root.
+	SymlinkReject
+
+	// SymlinkDereference archives the symlink's target content as a
+	// regular file in its place, instead of writing a symlink entry.
+	// Symlink chains are followed with cycle detection; a cycle causes
+	// Create to fail.
+	SymlinkDereference
+)
+
+// IllegalLinkError reports a symlink found during Create whose target
+// resolves outside of the source root, under SymlinkReject.
+type IllegalLinkError struct {
+	Name   string
+	Target string
+}
+
+func (e *IllegalLinkError) Error() string {
+	return fmt.Sprintf("targo: symlink %s points outside of the source root: %s", e.Name, e.Target)
+}
+
+// checkLinkWithinRoot returns an *IllegalLinkError if link, read from the
+// symlink at path, resolves outside of srcRoot.
+func checkLinkWithinRoot(path, link, srcRoot string) error {
+	resolved := link
+	if !filepath.IsAbs(resolved) {
+		resolved = filepath.Join(filepath.Dir(path), resolved)
+	}
+	resolved = filepath.Clean(resolved)
+
+	rootAbs, err := filepath.Abs(srcRoot)
+	if err != nil {
+		return err
+	}
+	resolvedAbs, err := filepath.Abs(resolved)
+	if err != nil {
+		return err
+	}
+
+	if resolvedAbs != rootAbs && !strings.HasPrefix(resolvedAbs, rootAbs+string(filepath.Separator)) {
+		return &IllegalLinkError{Name: path, Target: link}
+	}
+
+	return nil
+}
+
+// writeDereferencedEntry writes the file ultimately pointed to by the
+// symlink chain starting at path as a regular archive entry named name.
+func writeDereferencedEntry(tw *tar.Writer, path, name string, opts *CreateOptions) error {
+	resolved, info, err := dereferenceSymlink(path, map[interface{}]bool{})
+	if err != nil {
+		return err
+	}
+
+	hdr, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	hdr.Typeflag = tar.TypeReg
+	hdr.Name = filepath.ToSlash(name)
+	normalizeHeader(hdr, opts)
+
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+
+	f, err := os.Open(resolved)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// dereferenceSymlink follows the symlink chain starting at path until it
+// reaches a non-symlink, returning its path and os.FileInfo. visited tracks
+// the inodes seen so far in the chain; a repeated inode is reported as a
+// cycle instead of looping forever.
+func dereferenceSymlink(path string, visited map[interface{}]bool) (string, os.FileInfo, error) {
+	current := path
+
+	for {
+		info, err := os.Lstat(current)
+		if err != nil {
+			return "", nil, err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			return current, info, nil
+		}
+
+		if key, ok := inodeKey(current, info); ok {
+			if visited[key] {
+				return "", nil, fmt.Errorf("targo: symlink cycle detected while dereferencing %s", path)
+			}
+			visited[key] = true
+		}
+
+		link, err := os.Readlink(current)
+		if err != nil {
+			return "", nil, err
+		}
+		if !filepath.IsAbs(link) {
+			link = filepath.Join(filepath.Dir(current), link)
+		}
+		current = link
+	}
+}
@@ -0,0 +1,293 @@
+// Copyright 2015 Robin Hahling. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package targo provides a simple API to create and extract tar archives.
+package targo
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Create creates a tar archive at archivePath from the contents of srcDir.
+//
+// If srcDir does not end with a path separator, the archive contains srcDir
+// itself at its root (i.e. extracting the archive recreates srcDir). If
+// srcDir ends with a path separator, only the contents of srcDir are
+// archived, without the enclosing directory.
+func Create(archivePath, srcDir string) error {
+	return CreateWithOptions(archivePath, srcDir, nil)
+}
+
+// CreateWithOptions is like Create but lets the caller customize the
+// archiving behavior through opts, including the compression codec the
+// archive file is wrapped with. A nil opts behaves like a zero-value
+// CreateOptions.
+func CreateWithOptions(archivePath, srcDir string, opts *CreateOptions) error {
+	fi, err := os.Stat(srcDir)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("targo: not a directory: %s", srcDir)
+	}
+
+	f, err := os.Create(archivePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return CreateWriter(f, srcDir, opts)
+}
+
+// CreateWriter is like CreateWithOptions but writes the archive to w instead
+// of a file, letting callers pipe archives over sockets or HTTP without
+// touching disk.
+func CreateWriter(w io.Writer, srcDir string, opts *CreateOptions) error {
+	fi, err := os.Stat(srcDir)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("targo: not a directory: %s", srcDir)
+	}
+
+	cw, closeW, err := compressWriter(w, opts)
+	if err != nil {
+		return err
+	}
+	defer closeW()
+
+	tw := tar.NewWriter(cw)
+	defer tw.Close()
+
+	return writeDir(tw, srcDir, opts)
+}
+
+// writeDir walks srcDir and writes every entry found to tw.
+func writeDir(tw *tar.Writer, srcDir string, opts *CreateOptions) error {
+	cleanSrc := filepath.Clean(srcDir)
+	root := archiveRoot(srcDir)
+	links := hardlinks{}
+	var glob *globMatcher
+	if opts != nil && (len(opts.Includes) > 0 || len(opts.Excludes) > 0) {
+		glob = &globMatcher{includes: opts.Includes, excludes: opts.Excludes}
+	}
+	ignores := newIgnoreMatcher()
+
+	return filepath.Walk(cleanSrc, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == cleanSrc {
+			return ignores.enter(path)
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+
+		// Includes/Excludes/.targoignore/Matcher are always evaluated
+		// relative to srcDir itself, independent of the trailing-slash
+		// naming convention archiveRoot uses for archive entry names.
+		matchRel, err := filepath.Rel(cleanSrc, path)
+		if err != nil {
+			return err
+		}
+		matchName := filepath.ToSlash(matchRel)
+
+		// A directory that fails to match an Includes pattern itself may
+		// still have descendants that do (e.g. "sub" against "**/*.txt"),
+		// so only a genuine exclusion (.targoignore or Excludes) prunes the
+		// whole subtree; an Includes/Matcher rejection just skips this one
+		// entry and lets the walk continue into it.
+		if !ignores.Match(path) || (glob != nil && glob.Excluded(matchName)) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			if err := ignores.enter(path); err != nil {
+				return err
+			}
+		}
+		if (glob != nil && !glob.Match(matchName, info.IsDir())) ||
+			(opts != nil && opts.Matcher != nil && !opts.Matcher.Match(matchName, info.IsDir())) {
+			return nil
+		}
+
+		if opts != nil && opts.PreserveHardlinks {
+			if linkName, seen := links.linkedName(path, info); seen {
+				hdr := &tar.Header{
+					Typeflag: tar.TypeLink,
+					Name:     name,
+					Linkname: linkName,
+				}
+				normalizeHeader(hdr, opts)
+				return tw.WriteHeader(hdr)
+			}
+			links.record(path, name, info)
+		}
+
+		return writeEntry(tw, path, rel, info, opts, cleanSrc)
+	})
+}
+
+// archiveRoot returns the directory relative to which archive entry names
+// are computed, honoring the trailing-slash convention documented on
+// Create.
+func archiveRoot(srcDir string) string {
+	if strings.HasSuffix(srcDir, string(os.PathSeparator)) {
+		return filepath.Clean(srcDir)
+	}
+	return filepath.Dir(filepath.Clean(srcDir))
+}
+
+// writeEntry writes a single archive entry for path (whose archive name is
+// name) described by info. srcRoot is the directory being archived, used to
+// resolve symlink targets under CreateOptions.SymlinkPolicy.
+func writeEntry(tw *tar.Writer, path, name string, info os.FileInfo, opts *CreateOptions, srcRoot string) error {
+	if info.Mode()&os.ModeSymlink != 0 && opts != nil && opts.SymlinkPolicy == SymlinkDereference {
+		return writeDereferencedEntry(tw, path, name, opts)
+	}
+
+	var link string
+	if info.Mode()&os.ModeSymlink != 0 {
+		l, err := os.Readlink(path)
+		if err != nil {
+			return err
+		}
+		link = l
+
+		if opts != nil && opts.SymlinkPolicy == SymlinkReject {
+			if err := checkLinkWithinRoot(path, link, srcRoot); err != nil {
+				return err
+			}
+		}
+
+		if (opts != nil && opts.SymlinkPolicy == SymlinkRewriteRelative) ||
+			(opts != nil && opts.Deterministic) {
+			link = relativeSymlinkTarget(path, link)
+		}
+	}
+
+	hdr, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	hdr.Name = filepath.ToSlash(name)
+	if info.IsDir() {
+		hdr.Name += "/"
+	}
+	normalizeHeader(hdr, opts)
+
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+
+	if info.Mode().IsRegular() {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		if _, err := io.Copy(tw, f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CreateInPlace archives path (a file or a directory) into path+".tar" and
+// removes the original path on success.
+func CreateInPlace(path string) error {
+	return CreateInPlaceWithOptions(path, nil)
+}
+
+// CreateInPlaceWithOptions is like CreateInPlace but lets the caller select
+// the compression codec through opts.Compression; the resulting archive's
+// extension is chosen to match (e.g. ".tar.gz" for CompressionGzip).
+func CreateInPlaceWithOptions(path string, opts *CreateOptions) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() && filepath.Ext(path) != "" {
+		return fmt.Errorf("targo: cannot create in-place archive for directory with an extension: %s", path)
+	}
+
+	var compression Compression
+	if opts != nil {
+		compression = opts.Compression
+	}
+	archivePath := path + compressionExt(compression)
+
+	if fi.IsDir() {
+		if err := CreateWithOptions(archivePath, path, opts); err != nil {
+			return err
+		}
+	} else if err := createFileInPlace(archivePath, path, opts); err != nil {
+		return err
+	}
+
+	return os.RemoveAll(path)
+}
+
+// createFileInPlace archives the single file at path into archivePath,
+// keeping only its base name as the entry name.
+func createFileInPlace(archivePath, path string, opts *CreateOptions) error {
+	f, err := os.Create(archivePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	cw, closeW, err := compressWriter(f, opts)
+	if err != nil {
+		return err
+	}
+	defer closeW()
+
+	tw := tar.NewWriter(cw)
+	defer tw.Close()
+
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+
+	return writeEntry(tw, path, filepath.Base(path), info, opts, filepath.Dir(path))
+}
+
+// ExtractInPlace extracts the archive at archivePath (which must end in one
+// of the recognized archive extensions: ".tar", ".tar.gz", ".tgz",
+// ".tar.bz2", ".tar.xz" or ".tar.zst") into its parent directory and removes
+// the archive on success. The compression codec, if any, is detected from
+// the archive's content rather than its extension.
+//
+// Unlike Extract, ExtractInPlace always extracts in safe mode: entries whose
+// name or link target would resolve outside of the destination directory are
+// rejected. Use ExtractWithOptions directly if unsafe archives must be
+// supported.
+func ExtractInPlace(archivePath string) error {
+	if _, ok := splitArchiveExt(archivePath); !ok {
+		return fmt.Errorf("targo: not a tar archive: %s", archivePath)
+	}
+
+	if err := ExtractWithOptions(filepath.Dir(archivePath), archivePath, nil); err != nil {
+		return err
+	}
+
+	return os.Remove(archivePath)
+}
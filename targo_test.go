@@ -5,13 +5,20 @@
 package targo
 
 import (
+	"archive/tar"
+	"bytes"
 	"crypto/md5"
 	"encoding/hex"
 	"errors"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"runtime"
 	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
 )
 
 var (
@@ -89,6 +96,19 @@ func TestCreateExtractInPlace(t *testing.T) {
 		t.Fatal(errors.New("error expected when given a directory path with an extension"))
 	}
 
+	// void is an intentionally-unsafe fixture (an absolute symlink escaping
+	// dirPath) used by testFiles() to exercise the trusting Extract path.
+	// ExtractInPlace is safe by default and would reject it, so it is
+	// removed for the round trip below and restored afterwards.
+	if err := os.Remove(voidPath); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.Symlink("/void", voidPath); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
 	if err := CreateInPlace(dirPath); err != nil {
 		t.Fatal(err)
 	}
@@ -110,6 +130,143 @@ func TestCreateExtractInPlace(t *testing.T) {
 	}
 }
 
+func TestExtractWithOptionsRejectsTraversal(t *testing.T) {
+	destDir := "./testdata/traversal-dest"
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(destDir)
+
+	t.Run("EntryName", func(t *testing.T) {
+		archivePath := writeTestArchive(t, []tar.Header{
+			{Name: "../escaped.txt", Typeflag: tar.TypeReg, Mode: 0644, Size: 0},
+		})
+		defer os.Remove(archivePath)
+
+		var pathErr *PathTraversalError
+		if err := ExtractWithOptions(destDir, archivePath, nil); !errors.As(err, &pathErr) {
+			t.Fatalf("expected *PathTraversalError, got %v", err)
+		}
+	})
+
+	t.Run("SymlinkTarget", func(t *testing.T) {
+		archivePath := writeTestArchive(t, []tar.Header{
+			{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"},
+		})
+		defer os.Remove(archivePath)
+
+		var pathErr *PathTraversalError
+		if err := ExtractWithOptions(destDir, archivePath, nil); !errors.As(err, &pathErr) {
+			t.Fatalf("expected *PathTraversalError, got %v", err)
+		}
+	})
+}
+
+// writeTestArchive writes a tar archive made of the given headers (with no
+// body content) to a temporary file under testdata and returns its path.
+func writeTestArchive(t *testing.T, hdrs []tar.Header) string {
+	t.Helper()
+
+	f, err := ioutil.TempFile("./testdata", "malicious-*.tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	tw := tar.NewWriter(f)
+	for _, hdr := range hdrs {
+		if err := tw.WriteHeader(&hdr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return f.Name()
+}
+
+func TestExtractWithOptionsChroot(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("chroot sandbox is not supported on windows")
+	}
+	if os.Getuid() != 0 {
+		t.Skip("chroot(2) requires root privileges")
+	}
+
+	srcPath := "./testdata/chroot-src"
+	archivePath := srcPath + ".tar"
+	destPath := "./testdata/chroot-dest"
+
+	if err := os.MkdirAll(srcPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(srcPath)
+	if err := ioutil.WriteFile(srcPath+"/data.txt", []byte("chrooted content\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := CreateWithOptions(archivePath, srcPath, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(archivePath)
+
+	defer os.RemoveAll(destPath)
+	if err := ExtractWithOptions(destPath, archivePath, &ExtractOptions{Chroot: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ioutil.ReadFile(destPath + "/chroot-src/data.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "chrooted content\n" {
+		t.Errorf("unexpected content after chroot extraction: %q", got)
+	}
+}
+
+// TestExtractWithOptionsChrootCompressed covers Chroot combined with a
+// compressed archive: runChrootHelper must decompress the stream the same
+// way ExtractReader does before handing it to tar.NewReader.
+func TestExtractWithOptionsChrootCompressed(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("chroot sandbox is not supported on windows")
+	}
+	if os.Getuid() != 0 {
+		t.Skip("chroot(2) requires root privileges")
+	}
+
+	srcPath := "./testdata/chroot-gzip-src"
+	archivePath := srcPath + ".tar.gz"
+	destPath := "./testdata/chroot-gzip-dest"
+
+	if err := os.MkdirAll(srcPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(srcPath)
+	if err := ioutil.WriteFile(srcPath+"/data.txt", []byte("chrooted gzip content\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := CreateWithOptions(archivePath, srcPath, &CreateOptions{Compression: CompressionGzip}); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(archivePath)
+
+	defer os.RemoveAll(destPath)
+	if err := ExtractWithOptions(destPath, archivePath, &ExtractOptions{Chroot: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ioutil.ReadFile(destPath + "/chroot-gzip-src/data.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "chrooted gzip content\n" {
+		t.Errorf("unexpected content after chroot extraction: %q", got)
+	}
+}
+
 func testFiles() error {
 	var err error
 	stat := func(path string) (fi os.FileInfo) {
@@ -161,7 +318,7 @@ func testFiles() error {
 		return err
 	}
 
-	someContentChecksum := "258622b1688250cb619f3c9ccaefb7eb"
+	someContentChecksum := "eb9c2bf0eb63f3a7bc0ea37ef18aeba5"
 	someContent, err := ioutil.ReadFile(someContentPath)
 	if err != nil {
 		return err
@@ -217,3 +374,428 @@ func checkSymlinkDest(expDest, path string) error {
 
 	return nil
 }
+
+func TestCreateWithOptionsPreserveHardlinks(t *testing.T) {
+	hardlinkDirPath := "./testdata/hardlinks"
+	hardlinkArchivePath := hardlinkDirPath + ".tar"
+	origPath := hardlinkDirPath + "/orig.txt"
+	linkPath := hardlinkDirPath + "/link.txt"
+	restorePath := "./testdata/hardlinks-restored"
+
+	if err := os.MkdirAll(hardlinkDirPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(hardlinkDirPath)
+
+	if err := ioutil.WriteFile(origPath, []byte("shared content\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(origPath, linkPath); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := &CreateOptions{PreserveHardlinks: true}
+	if err := CreateWithOptions(hardlinkArchivePath, hardlinkDirPath, opts); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(hardlinkArchivePath)
+
+	defer os.RemoveAll(restorePath)
+	if err := Extract(restorePath, hardlinkArchivePath); err != nil {
+		t.Fatal(err)
+	}
+
+	restoredOrig := restorePath + "/hardlinks/orig.txt"
+	restoredLink := restorePath + "/hardlinks/link.txt"
+
+	origFi, err := os.Stat(restoredOrig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	linkFi, err := os.Stat(restoredLink)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(origFi, linkFi) {
+		t.Error("expected restored files to share an inode after extraction")
+	}
+}
+
+func TestCreateInPlaceWithOptionsCompression(t *testing.T) {
+	srcPath := "./testdata/gzip-src"
+	restorePath := "./testdata/gzip-restored"
+
+	if err := os.MkdirAll(srcPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(srcPath+"/data.txt", []byte("compress me\n"), 0644); err != nil {
+		os.RemoveAll(srcPath)
+		t.Fatal(err)
+	}
+
+	opts := &CreateOptions{Compression: CompressionGzip}
+	if err := CreateInPlaceWithOptions(srcPath, opts); err != nil {
+		t.Fatal(err)
+	}
+	archivePath := srcPath + ".tar.gz"
+	defer os.Remove(archivePath)
+
+	if _, err := os.Stat(srcPath); err == nil {
+		t.Error(errors.New("directory not removed: " + srcPath))
+	}
+
+	defer os.RemoveAll(restorePath)
+	if err := Extract(restorePath, archivePath); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ioutil.ReadFile(restorePath + "/gzip-src/data.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "compress me\n" {
+		t.Errorf("unexpected content after gzip round-trip: %q", got)
+	}
+}
+
+func TestExtractZstdReleasesGoroutines(t *testing.T) {
+	srcPath := "./testdata/zstd-src"
+	restorePath := "./testdata/zstd-restored"
+
+	if err := os.MkdirAll(srcPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(srcPath)
+	if err := ioutil.WriteFile(srcPath+"/data.txt", []byte("compress me\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := &CreateOptions{Compression: CompressionZstd}
+	archivePath := srcPath + ".tar.zst"
+	if err := CreateWithOptions(archivePath, srcPath, opts); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(archivePath)
+	defer os.RemoveAll(restorePath)
+
+	before := runtime.NumGoroutine()
+
+	if err := Extract(restorePath, archivePath); err != nil {
+		t.Fatal(err)
+	}
+
+	assertGoroutinesSettle(t, before)
+}
+
+// TestExtractZstdReleasesGoroutinesOnError covers the case the happy path
+// above can't: extraction aborting before the compressed stream is fully
+// read. The zstd decoder's background goroutines block waiting for a
+// consumer that will never come back unless the decoder is explicitly
+// closed.
+func TestExtractZstdReleasesGoroutinesOnError(t *testing.T) {
+	var buf bytes.Buffer
+	zw, err := zstd.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw := tar.NewWriter(zw)
+	if err := tw.WriteHeader(&tar.Header{
+		Name:     "bogus",
+		Typeflag: tar.TypeChar,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// A second, larger entry that extractEntries will never reach, so the
+	// compressed stream still has unread data once it bails out above.
+	padding := make([]byte, 8<<20)
+	if err := tw.WriteHeader(&tar.Header{
+		Name:     "padding",
+		Typeflag: tar.TypeReg,
+		Mode:     0644,
+		Size:     int64(len(padding)),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write(padding); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	before := runtime.NumGoroutine()
+
+	err = ExtractReader(&buf, t.TempDir(), nil)
+	if err == nil {
+		t.Fatal("expected an error extracting an unsupported entry type")
+	}
+
+	assertGoroutinesSettle(t, before)
+}
+
+// assertGoroutinesSettle fails t if runtime.NumGoroutine() does not return to
+// at most before within a short polling window.
+func assertGoroutinesSettle(t *testing.T, before int) {
+	t.Helper()
+
+	var after int
+	for i := 0; i < 50; i++ {
+		after = runtime.NumGoroutine()
+		if after <= before {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("goroutine count did not settle: before=%d after=%d", before, after)
+}
+
+func TestCreateWithOptionsFilters(t *testing.T) {
+	filterDirPath := "./testdata/filter-src"
+	archivePath := filterDirPath + ".tar"
+
+	opts := &CreateOptions{Excludes: []string{"vendor/**", "*.log"}}
+	if err := CreateWithOptions(archivePath, filterDirPath, opts); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(archivePath)
+
+	names, err := archiveEntryNames(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"filter-src/keep.txt", "filter-src/sub/keep.tmp", "filter-src/sub/b.txt"} {
+		if !names[want] {
+			t.Errorf("expected %s in archive, got %v", want, names)
+		}
+	}
+	for _, notWant := range []string{"filter-src/skip.log", "filter-src/vendor/ignored.txt", "filter-src/vendor/", "filter-src/sub/a.tmp"} {
+		if names[notWant] {
+			t.Errorf("expected %s to be excluded from archive, got %v", notWant, names)
+		}
+	}
+}
+
+func TestCreateWithOptionsFiltersIncludes(t *testing.T) {
+	filterDirPath := "./testdata/filter-src"
+	archivePath := filterDirPath + "-includes.tar"
+
+	opts := &CreateOptions{Includes: []string{"**/*.txt"}}
+	if err := CreateWithOptions(archivePath, filterDirPath, opts); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(archivePath)
+
+	names, err := archiveEntryNames(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// sub itself doesn't match "**/*.txt", but that must not prune the
+	// subtree: sub/b.txt matches and has to still be reached.
+	for _, want := range []string{"filter-src/keep.txt", "filter-src/sub/b.txt", "filter-src/vendor/ignored.txt"} {
+		if !names[want] {
+			t.Errorf("expected %s in archive, got %v", want, names)
+		}
+	}
+	for _, notWant := range []string{"filter-src/skip.log", "filter-src/sub/a.tmp", "filter-src/sub/keep.tmp"} {
+		if names[notWant] {
+			t.Errorf("expected %s to be excluded from archive, got %v", notWant, names)
+		}
+	}
+}
+
+func TestCreateWithOptionsDeterministic(t *testing.T) {
+	srcPath := "./testdata/deterministic-src"
+	firstArchive := "./testdata/deterministic-first.tar"
+	secondArchive := "./testdata/deterministic-second.tar"
+
+	if err := os.MkdirAll(srcPath+"/sub", 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(srcPath)
+
+	if err := ioutil.WriteFile(srcPath+"/a.txt", []byte("a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(srcPath+"/sub/b.txt", []byte("b\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := &CreateOptions{Deterministic: true}
+
+	if err := CreateWithOptions(firstArchive, srcPath, opts); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(firstArchive)
+
+	if err := CreateWithOptions(secondArchive, srcPath, opts); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(secondArchive)
+
+	firstSum, err := Checksum(firstArchive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secondSum, err := Checksum(secondArchive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if firstSum != secondSum {
+		t.Errorf("expected identical checksums for deterministic archives, got %s and %s", firstSum, secondSum)
+	}
+
+	f, err := os.Open(firstArchive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Format != tar.FormatPAX {
+			t.Errorf("expected PAX format for entry %s, got %v", hdr.Name, hdr.Format)
+		}
+		if hdr.ModTime.Unix() != 0 {
+			t.Errorf("expected ModTime at the Unix epoch for entry %s, got %v", hdr.Name, hdr.ModTime)
+		}
+		if hdr.Uid != 0 || hdr.Gid != 0 || hdr.Uname != "" || hdr.Gname != "" {
+			t.Errorf("expected stripped ownership for entry %s, got uid=%d gid=%d uname=%q gname=%q",
+				hdr.Name, hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname)
+		}
+	}
+}
+
+func TestCreateWithOptionsSymlinkPolicy(t *testing.T) {
+	srcPath := "./testdata/symlink-src"
+
+	if err := os.MkdirAll(srcPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(srcPath)
+
+	if err := ioutil.WriteFile(srcPath+"/target.txt", []byte("target content\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("target.txt", srcPath+"/relative-link"); err != nil {
+		t.Fatal(err)
+	}
+	outsideAbs, err := filepath.Abs("./testdata/foo.io")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outsideAbs, srcPath+"/outside-link"); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("RewriteRelative", func(t *testing.T) {
+		archivePath := srcPath + "-rewrite.tar"
+		opts := &CreateOptions{SymlinkPolicy: SymlinkRewriteRelative}
+		if err := CreateWithOptions(archivePath, srcPath, opts); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(archivePath)
+
+		links, err := archiveSymlinkTargets(archivePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if filepath.IsAbs(links["symlink-src/outside-link"]) {
+			t.Errorf("expected outside-link target to be rewritten relative, got %q", links["symlink-src/outside-link"])
+		}
+	})
+
+	t.Run("Reject", func(t *testing.T) {
+		archivePath := srcPath + "-reject.tar"
+		opts := &CreateOptions{SymlinkPolicy: SymlinkReject}
+		err := CreateWithOptions(archivePath, srcPath, opts)
+		defer os.Remove(archivePath)
+
+		var linkErr *IllegalLinkError
+		if !errors.As(err, &linkErr) {
+			t.Fatalf("expected *IllegalLinkError, got %v", err)
+		}
+	})
+
+	t.Run("Dereference", func(t *testing.T) {
+		archivePath := srcPath + "-deref.tar"
+		opts := &CreateOptions{SymlinkPolicy: SymlinkDereference}
+
+		if err := os.Remove(srcPath + "/outside-link"); err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			if err := os.Symlink(outsideAbs, srcPath+"/outside-link"); err != nil {
+				t.Fatal(err)
+			}
+		}()
+
+		if err := CreateWithOptions(archivePath, srcPath, opts); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(archivePath)
+
+		names, err := archiveEntryNames(archivePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !names["symlink-src/relative-link"] {
+			t.Errorf("expected dereferenced link to be archived as a regular entry, got %v", names)
+		}
+	})
+}
+
+func archiveSymlinkTargets(archivePath string) (map[string]string, error) {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	links := make(map[string]string)
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return links, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag == tar.TypeSymlink {
+			links[hdr.Name] = hdr.Linkname
+		}
+	}
+}
+
+func archiveEntryNames(archivePath string) (map[string]bool, error) {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	names := make(map[string]bool)
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return names, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		names[hdr.Name] = true
+	}
+}